@@ -0,0 +1,168 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// cgroupRootV2ControllersFile is present only on systems using the unified
+// cgroups v2 hierarchy.
+const cgroupRootV2ControllersFile = "/sys/fs/cgroup/cgroup.controllers"
+
+// ResourceLimits holds the resource constraints requested for a container via
+// the --memory, --cpus and --pids flags. A zero value for a field means "no
+// limit requested".
+type ResourceLimits struct {
+	MemoryBytes int64
+	CPUs        float64
+	PidsLimit   int64
+}
+
+// IsEmpty reports whether no resource limits were requested at all.
+func (limits ResourceLimits) IsEmpty() bool {
+	return limits.MemoryBytes == 0 && limits.CPUs == 0 && limits.PidsLimit == 0
+}
+
+// cgroupsV2Available reports whether the host uses the unified cgroups v2
+// hierarchy, detected by the presence of the controllers file.
+func cgroupsV2Available() bool {
+	_, err := os.Stat(cgroupRootV2ControllersFile)
+	return err == nil
+}
+
+// applyCgroupLimits creates a per-container cgroup, applies the requested
+// memory/CPU/pids limits, and places the current process into it. It must be
+// called from inside the container's own process (the child), since joining
+// a cgroup only affects the calling process and its future children.
+func applyCgroupLimits(containerId string, limits ResourceLimits) error {
+	if limits.IsEmpty() {
+		fmt.Println("No resource limits requested, skipping cgroup setup")
+		return nil
+	}
+
+	if cgroupsV2Available() {
+		return applyCgroupLimitsV2(containerId, limits)
+	}
+	return applyCgroupLimitsV1(containerId, limits)
+}
+
+func applyCgroupLimitsV2(containerId string, limits ResourceLimits) error {
+	cgroupPath := filepath.Join("/sys/fs/cgroup/cocker", containerId)
+	fmt.Println("Applying cgroups v2 limits under", cgroupPath)
+	if err := os.MkdirAll(cgroupPath, 0755); err != nil {
+		return fmt.Errorf("failed to create cgroup directory %s: %w", cgroupPath, err)
+	}
+	// The memory.max, cpu.max and pids.max files only appear in the child
+	// cgroup once the parent enables those controllers for its children.
+	if err := writeCgroupFile(filepath.Dir(cgroupPath), "cgroup.subtree_control", "+memory +cpu +pids"); err != nil {
+		return err
+	}
+
+	if limits.MemoryBytes > 0 {
+		if err := writeCgroupFile(cgroupPath, "memory.max", strconv.FormatInt(limits.MemoryBytes, 10)); err != nil {
+			return err
+		}
+	}
+
+	if limits.CPUs > 0 {
+		const period = 100000
+		quota := int64(limits.CPUs * float64(period))
+		value := fmt.Sprintf("%d %d", quota, period)
+		if err := writeCgroupFile(cgroupPath, "cpu.max", value); err != nil {
+			return err
+		}
+	}
+
+	if limits.PidsLimit > 0 {
+		if err := writeCgroupFile(cgroupPath, "pids.max", strconv.FormatInt(limits.PidsLimit, 10)); err != nil {
+			return err
+		}
+	}
+
+	return writeCgroupFile(cgroupPath, "cgroup.procs", strconv.Itoa(os.Getpid()))
+}
+
+// applyCgroupLimitsV1 falls back to the legacy per-controller hierarchies
+// (memory, cpu, pids) used before cgroups v2.
+func applyCgroupLimitsV1(containerId string, limits ResourceLimits) error {
+	fmt.Println("cgroups v2 not available, falling back to legacy v1 hierarchies")
+
+	if limits.MemoryBytes > 0 {
+		memoryPath := filepath.Join("/sys/fs/cgroup/memory/cocker", containerId)
+		if err := os.MkdirAll(memoryPath, 0755); err != nil {
+			return fmt.Errorf("failed to create memory cgroup directory %s: %w", memoryPath, err)
+		}
+		if err := writeCgroupFile(memoryPath, "memory.limit_in_bytes", strconv.FormatInt(limits.MemoryBytes, 10)); err != nil {
+			return err
+		}
+		if err := writeCgroupFile(memoryPath, "cgroup.procs", strconv.Itoa(os.Getpid())); err != nil {
+			return err
+		}
+	}
+
+	if limits.CPUs > 0 {
+		cpuPath := filepath.Join("/sys/fs/cgroup/cpu/cocker", containerId)
+		if err := os.MkdirAll(cpuPath, 0755); err != nil {
+			return fmt.Errorf("failed to create cpu cgroup directory %s: %w", cpuPath, err)
+		}
+		const period = 100000
+		quota := int64(limits.CPUs * float64(period))
+		if err := writeCgroupFile(cpuPath, "cpu.cfs_period_us", strconv.Itoa(period)); err != nil {
+			return err
+		}
+		if err := writeCgroupFile(cpuPath, "cpu.cfs_quota_us", strconv.FormatInt(quota, 10)); err != nil {
+			return err
+		}
+		if err := writeCgroupFile(cpuPath, "cgroup.procs", strconv.Itoa(os.Getpid())); err != nil {
+			return err
+		}
+	}
+
+	if limits.PidsLimit > 0 {
+		pidsPath := filepath.Join("/sys/fs/cgroup/pids/cocker", containerId)
+		if err := os.MkdirAll(pidsPath, 0755); err != nil {
+			return fmt.Errorf("failed to create pids cgroup directory %s: %w", pidsPath, err)
+		}
+		if err := writeCgroupFile(pidsPath, "pids.max", strconv.FormatInt(limits.PidsLimit, 10)); err != nil {
+			return err
+		}
+		if err := writeCgroupFile(pidsPath, "cgroup.procs", strconv.Itoa(os.Getpid())); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func writeCgroupFile(cgroupPath string, fileName string, value string) error {
+	fullPath := filepath.Join(cgroupPath, fileName)
+	if err := os.WriteFile(fullPath, []byte(value), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", fullPath, err)
+	}
+	return nil
+}
+
+// removeCgroupLimits tears down the cgroup directories created for
+// containerId. It must be called from the parent process after the child
+// has exited (e.g. once Wait returns), since the child itself is a member
+// of the cgroup until it exits and rmdir on a non-empty cgroup fails.
+func removeCgroupLimits(containerId string) {
+	candidatePaths := []string{
+		filepath.Join("/sys/fs/cgroup/cocker", containerId),
+		filepath.Join("/sys/fs/cgroup/memory/cocker", containerId),
+		filepath.Join("/sys/fs/cgroup/cpu/cocker", containerId),
+		filepath.Join("/sys/fs/cgroup/pids/cocker", containerId),
+	}
+	for _, path := range candidatePaths {
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+		if err := os.Remove(path); err != nil {
+			fmt.Println("Warning: failed to remove cgroup directory", path, ":", err)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+//go:build linux
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Container lifecycle states, as persisted in ContainerState.Status.
+const (
+	StatusRunning = "running"
+	StatusExited  = "exited"
+	StatusStopped = "stopped"
+)
+
+// ContainerState is the full record of a container's identity and lifecycle,
+// persisted as JSON to containers/<id>/state.json so that `ps`, `stop`, `rm`,
+// `logs`, `inspect` and `exec` all have a single source of truth.
+type ContainerState struct {
+	Id        string
+	Pid       int
+	Image     string
+	Command   string
+	Args      []string
+	Volumes   []string
+	Limits    ResourceLimits
+	Status    string
+	CreatedAt time.Time
+	ExitCode  int
+}
+
+func containerStatePath(containerId string) string {
+	return filepath.Join(containersDirectory, containerId, "state.json")
+}
+
+// writeContainerState persists state to containers/<id>/state.json,
+// overwriting whatever was there before.
+func writeContainerState(state ContainerState) error {
+	stateJSON, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal state for container %q: %w", state.Id, err)
+	}
+	containerDir := filepath.Join(containersDirectory, state.Id)
+	if err := os.MkdirAll(containerDir, 0700); err != nil {
+		return fmt.Errorf("failed to create container directory %q: %w", containerDir, err)
+	}
+	if err := os.WriteFile(containerStatePath(state.Id), stateJSON, 0644); err != nil {
+		return fmt.Errorf("failed to write state for container %q: %w", state.Id, err)
+	}
+	return nil
+}
+
+// readContainerState loads the persisted state for containerId.
+func readContainerState(containerId string) (ContainerState, error) {
+	var state ContainerState
+	stateJSON, err := os.ReadFile(containerStatePath(containerId))
+	if err != nil {
+		return state, fmt.Errorf("failed to read state for container %q: %w", containerId, err)
+	}
+	if err := json.Unmarshal(stateJSON, &state); err != nil {
+		return state, fmt.Errorf("failed to parse state for container %q: %w", containerId, err)
+	}
+	return state, nil
+}
@@ -0,0 +1,142 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"syscall"
+
+	"github.com/creack/pty"
+	"golang.org/x/sys/unix"
+)
+
+// execChildNamespaces lists the namespaces an exec session must join, in the
+// order they should be entered.
+var execChildNamespaces = []string{"mnt", "uts", "pid", "net", "ipc"}
+
+// handleContainerExec implements `cocker exec [-it] <containerId> <command> [args...]`,
+// attaching a new process to an already-running container.
+func handleContainerExec() {
+	interactive := false
+	var positionalArgs []string
+
+	for _, argument := range os.Args[2:] {
+		if argument == "-it" || argument == "-i" || argument == "-t" {
+			interactive = true
+			fmt.Println("Interactive TTY requested via", argument)
+			continue
+		}
+		positionalArgs = append(positionalArgs, argument)
+	}
+
+	if len(positionalArgs) < 2 {
+		log.Fatal("Error: Usage: cocker exec [-it] <containerId> <command> [args...]")
+	}
+
+	containerId := positionalArgs[0]
+	commandAndArgs := positionalArgs[1:]
+
+	state, err := readContainerState(containerId)
+	if err != nil {
+		log.Fatal("Failed to look up container '"+containerId+"': ", err)
+	}
+	if state.Status != StatusRunning || !processAlive(state.Pid) {
+		log.Fatal("Error: container '" + containerId + "' is not running")
+	}
+	initPid := state.Pid
+
+	ourExecutablePath, err := os.Executable()
+	if err != nil {
+		log.Fatal("Failed to get our own executable path: ", err)
+	}
+
+	execChildArgs := append([]string{"_exec_child", strconv.Itoa(initPid)}, commandAndArgs...)
+	execChildCommand := exec.Command(ourExecutablePath, execChildArgs...)
+
+	if interactive {
+		ptyMaster, err := pty.Start(execChildCommand)
+		if err != nil {
+			log.Fatal("Failed to allocate pty for exec session: ", err)
+		}
+		defer ptyMaster.Close()
+
+		go io.Copy(ptyMaster, os.Stdin)
+		io.Copy(os.Stdout, ptyMaster)
+
+		if err := execChildCommand.Wait(); err != nil {
+			if exitError, ok := err.(*exec.ExitError); ok {
+				os.Exit(exitError.ExitCode())
+			}
+			log.Fatal("exec session exited with an error: ", err)
+		}
+		return
+	}
+
+	execChildCommand.Stdin = os.Stdin
+	execChildCommand.Stdout = os.Stdout
+	execChildCommand.Stderr = os.Stderr
+	if err := execChildCommand.Run(); err != nil {
+		if exitError, ok := err.(*exec.ExitError); ok {
+			os.Exit(exitError.ExitCode())
+		}
+		log.Fatal("Failed to exec into container: ", err)
+	}
+}
+
+// handleExecChild is re-exec'd by handleContainerExec as `_exec_child <pid> <command> [args...]`.
+// It joins the target container's namespaces on a locked OS thread and then
+// forks and execs the requested command. A fork is required rather than
+// exec'ing in place: setns(2) into a PID namespace only affects the PID
+// namespace of processes forked after the call, never the calling process's
+// own membership, so the command has to run in a child of the setns'd
+// process to actually land in the container's PID namespace.
+func handleExecChild() {
+	if len(os.Args) < 4 {
+		log.Fatal("Error: _exec_child requires a pid and a command")
+	}
+
+	targetPid := os.Args[2]
+	commandToRun := os.Args[3]
+	commandArgs := os.Args[3:]
+
+	runtime.LockOSThread()
+
+	for _, namespace := range execChildNamespaces {
+		nsPath := filepath.Join("/proc", targetPid, "ns", namespace)
+		nsFile, err := os.Open(nsPath)
+		if err != nil {
+			log.Fatal("Failed to open namespace file "+nsPath+": ", err)
+		}
+		if err := unix.Setns(int(nsFile.Fd()), 0); err != nil {
+			log.Fatal("Failed to setns into "+namespace+" namespace: ", err)
+		}
+		nsFile.Close()
+	}
+
+	binaryPath, err := exec.LookPath(commandToRun)
+	if err != nil {
+		binaryPath = commandToRun
+	}
+
+	procAttr := &syscall.ProcAttr{
+		Env:   os.Environ(),
+		Files: []uintptr{os.Stdin.Fd(), os.Stdout.Fd(), os.Stderr.Fd()},
+	}
+	childPid, err := syscall.ForkExec(binaryPath, commandArgs, procAttr)
+	if err != nil {
+		log.Fatal("Failed to fork/exec target command inside container: ", err)
+	}
+
+	var waitStatus syscall.WaitStatus
+	if _, err := syscall.Wait4(childPid, &waitStatus, 0, nil); err != nil {
+		log.Fatal("Failed to wait for exec child: ", err)
+	}
+	os.Exit(waitStatus.ExitStatus())
+}
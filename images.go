@@ -0,0 +1,473 @@
+//go:build linux
+
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// imagesDirectory is the on-disk image store: one directory per
+// "name:tag" reference, each holding a rootfs.tar.gz and a manifest.json.
+var imagesDirectory string = "./images"
+
+// defaultRegistry and defaultNamespace mirror the defaults `docker pull`
+// would use for an unqualified reference like "ubuntu:22.04".
+const (
+	defaultRegistry  = "registry-1.docker.io"
+	defaultNamespace = "library"
+)
+
+// ImageManifestLayer is one content-addressed layer of a pulled image.
+type ImageManifestLayer struct {
+	Digest string
+	Size   int64
+}
+
+// ImageManifest is the manifest.json persisted alongside an image's
+// flattened rootfs.tar.gz.
+type ImageManifest struct {
+	Reference string
+	Digest    string
+	Layers    []ImageManifestLayer
+	PulledAt  time.Time
+}
+
+// parseImageReference splits a "name[:tag]" reference into its name and tag,
+// defaulting the tag to "latest".
+func parseImageReference(ref string) (name string, tag string) {
+	if colonIndex := strings.LastIndex(ref, ":"); colonIndex != -1 && !strings.Contains(ref[colonIndex:], "/") {
+		return ref[:colonIndex], ref[colonIndex+1:]
+	}
+	return ref, "latest"
+}
+
+func imageDirectory(ref string) string {
+	name, tag := parseImageReference(ref)
+	return filepath.Join(imagesDirectory, name+":"+tag)
+}
+
+func imageRootfsTarballPath(ref string) string {
+	return filepath.Join(imageDirectory(ref), "rootfs.tar.gz")
+}
+
+// resolveImageRootfsTarball resolves ref to a locally-pulled rootfs tarball,
+// replacing the old hard-coded rootFileSystemTarball global.
+func resolveImageRootfsTarball(ref string) (string, error) {
+	tarballPath := imageRootfsTarballPath(ref)
+	if _, err := os.Stat(tarballPath); err != nil {
+		return "", fmt.Errorf("image %q not found locally; run 'cocker pull %s' first: %w", ref, ref, err)
+	}
+	return tarballPath, nil
+}
+
+// handleImagePull implements `cocker pull <name[:tag]>`: it authenticates
+// against the registry's token challenge, fetches the image manifest, pulls
+// and verifies each layer, flattens them (honoring whiteout files) into a
+// single rootfs tarball, and records a manifest.json for the image.
+func handleImagePull() {
+	if len(os.Args) < 3 {
+		log.Fatal("Error: Usage: cocker pull <name[:tag]>")
+	}
+	ref := os.Args[2]
+	name, tag := parseImageReference(ref)
+	repository := name
+	if !strings.Contains(repository, "/") {
+		repository = defaultNamespace + "/" + repository
+	}
+
+	fmt.Println("Pulling", ref, "from", defaultRegistry)
+
+	token, err := fetchRegistryToken(repository)
+	if err != nil {
+		log.Fatal("Failed to authenticate with registry: ", err)
+	}
+
+	manifest, err := fetchImageManifest(repository, tag, token)
+	if err != nil {
+		log.Fatal("Failed to fetch image manifest: ", err)
+	}
+	fmt.Println("Manifest has", len(manifest.Layers), "layer(s)")
+
+	layerRootDir, err := os.MkdirTemp("", "cocker-pull-")
+	if err != nil {
+		log.Fatal("Failed to create temporary layer directory: ", err)
+	}
+	defer os.RemoveAll(layerRootDir)
+
+	for _, layer := range manifest.Layers {
+		fmt.Println("Fetching layer", layer.Digest)
+		layerTarballPath, err := fetchImageLayer(repository, layer, token)
+		if err != nil {
+			log.Fatal("Failed to fetch layer "+layer.Digest+": ", err)
+		}
+		if err := extractLayerWithWhiteouts(layerTarballPath, layerRootDir); err != nil {
+			log.Fatal("Failed to extract layer "+layer.Digest+": ", err)
+		}
+		os.Remove(layerTarballPath)
+	}
+
+	destinationDir := imageDirectory(ref)
+	if err := os.MkdirAll(destinationDir, 0755); err != nil {
+		log.Fatal("Failed to create image directory: ", err)
+	}
+
+	if err := createRootfsTarball(layerRootDir, filepath.Join(destinationDir, "rootfs.tar.gz")); err != nil {
+		log.Fatal("Failed to assemble flattened rootfs: ", err)
+	}
+
+	manifestRecord := ImageManifest{
+		Reference: name + ":" + tag,
+		Digest:    manifest.Digest,
+		Layers:    manifest.Layers,
+		PulledAt:  time.Now(),
+	}
+	if err := writeImageManifest(destinationDir, manifestRecord); err != nil {
+		log.Fatal("Failed to write image manifest: ", err)
+	}
+
+	fmt.Println("Pulled", ref)
+}
+
+// handleImagesList implements `cocker images`.
+func handleImagesList() {
+	fmt.Println("\n===== IMAGES =====")
+	entries, err := os.ReadDir(imagesDirectory)
+	if err != nil {
+		if os.IsNotExist(err) {
+			fmt.Println("No images found.")
+			return
+		}
+		log.Fatal("Failed to read images directory: ", err)
+	}
+	if len(entries) == 0 {
+		fmt.Println("No images found.")
+		return
+	}
+
+	fmt.Println("REFERENCE\t\t\tPULLED")
+	fmt.Println("--------------------------------------------")
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		manifest, err := readImageManifest(filepath.Join(imagesDirectory, entry.Name()))
+		if err != nil {
+			fmt.Printf("Warning: could not read manifest for image '%s': %v\n", entry.Name(), err)
+			continue
+		}
+		fmt.Printf("%s\t%s\n", manifest.Reference, manifest.PulledAt.Format(time.UnixDate))
+	}
+}
+
+// handleImageRemove implements `cocker rmi <name[:tag]>`.
+func handleImageRemove() {
+	if len(os.Args) < 3 {
+		log.Fatal("Error: Usage: cocker rmi <name[:tag]>")
+	}
+	ref := os.Args[2]
+	imageDir := imageDirectory(ref)
+	if _, err := os.Stat(imageDir); err != nil {
+		log.Fatal("Image '"+ref+"' not found: ", err)
+	}
+	if err := os.RemoveAll(imageDir); err != nil {
+		log.Fatal("Failed to remove image '"+ref+"': ", err)
+	}
+	fmt.Println("Removed image", ref)
+}
+
+func writeImageManifest(imageDir string, manifest ImageManifest) error {
+	manifestJSON, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal image manifest: %w", err)
+	}
+	return os.WriteFile(filepath.Join(imageDir, "manifest.json"), manifestJSON, 0644)
+}
+
+func readImageManifest(imageDir string) (ImageManifest, error) {
+	var manifest ImageManifest
+	manifestJSON, err := os.ReadFile(filepath.Join(imageDir, "manifest.json"))
+	if err != nil {
+		return manifest, fmt.Errorf("failed to read image manifest: %w", err)
+	}
+	if err := json.Unmarshal(manifestJSON, &manifest); err != nil {
+		return manifest, fmt.Errorf("failed to parse image manifest: %w", err)
+	}
+	return manifest, nil
+}
+
+// registryAuthChallenge is the parsed content of a Www-Authenticate: Bearer
+// challenge header.
+type registryAuthChallenge struct {
+	Realm   string
+	Service string
+	Scope   string
+}
+
+func fetchWwwAuthenticateChallenge(registry string) (registryAuthChallenge, error) {
+	response, err := http.Get("https://" + registry + "/v2/")
+	if err != nil {
+		return registryAuthChallenge{}, err
+	}
+	defer response.Body.Close()
+
+	header := response.Header.Get("Www-Authenticate")
+	if header == "" {
+		return registryAuthChallenge{}, fmt.Errorf("registry %q did not present a Www-Authenticate challenge", registry)
+	}
+	return parseWwwAuthenticateHeader(header)
+}
+
+func parseWwwAuthenticateHeader(header string) (registryAuthChallenge, error) {
+	header = strings.TrimPrefix(header, "Bearer ")
+	var challenge registryAuthChallenge
+	for _, field := range strings.Split(header, ",") {
+		keyValue := strings.SplitN(strings.TrimSpace(field), "=", 2)
+		if len(keyValue) != 2 {
+			continue
+		}
+		value := strings.Trim(keyValue[1], `"`)
+		switch keyValue[0] {
+		case "realm":
+			challenge.Realm = value
+		case "service":
+			challenge.Service = value
+		case "scope":
+			challenge.Scope = value
+		}
+	}
+	if challenge.Realm == "" {
+		return challenge, fmt.Errorf("could not parse Www-Authenticate header: %s", header)
+	}
+	return challenge, nil
+}
+
+// fetchRegistryToken performs token auth against the registry's
+// Www-Authenticate challenge and returns a bearer token scoped to pull
+// repository.
+func fetchRegistryToken(repository string) (string, error) {
+	challenge, err := fetchWwwAuthenticateChallenge(defaultRegistry)
+	if err != nil {
+		return "", err
+	}
+
+	scope := challenge.Scope
+	if scope == "" {
+		scope = "repository:" + repository + ":pull"
+	}
+	tokenURL := fmt.Sprintf("%s?service=%s&scope=%s", challenge.Realm, url.QueryEscape(challenge.Service), url.QueryEscape(scope))
+
+	response, err := http.Get(tokenURL)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token request failed with status %s", response.Status)
+	}
+
+	var tokenResponse struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&tokenResponse); err != nil {
+		return "", err
+	}
+	if tokenResponse.Token != "" {
+		return tokenResponse.Token, nil
+	}
+	return tokenResponse.AccessToken, nil
+}
+
+// fetchImageManifest fetches and parses the image manifest for
+// repository:tag, accepting both the OCI and Docker v2 manifest media types.
+func fetchImageManifest(repository string, tag string, token string) (ImageManifest, error) {
+	manifestURL := fmt.Sprintf("https://%s/v2/%s/manifests/%s", defaultRegistry, repository, tag)
+	request, err := http.NewRequest(http.MethodGet, manifestURL, nil)
+	if err != nil {
+		return ImageManifest{}, err
+	}
+	request.Header.Set("Authorization", "Bearer "+token)
+	request.Header.Set("Accept", strings.Join([]string{
+		"application/vnd.oci.image.manifest.v1+json",
+		"application/vnd.docker.distribution.manifest.v2+json",
+	}, ", "))
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return ImageManifest{}, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return ImageManifest{}, fmt.Errorf("manifest request for %s:%s failed with status %s", repository, tag, response.Status)
+	}
+
+	var manifestBody struct {
+		Layers []struct {
+			Digest string `json:"digest"`
+			Size   int64  `json:"size"`
+		} `json:"layers"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&manifestBody); err != nil {
+		return ImageManifest{}, err
+	}
+
+	manifest := ImageManifest{Digest: response.Header.Get("Docker-Content-Digest")}
+	for _, layer := range manifestBody.Layers {
+		manifest.Layers = append(manifest.Layers, ImageManifestLayer{Digest: layer.Digest, Size: layer.Size})
+	}
+	return manifest, nil
+}
+
+// fetchImageLayer downloads the blob for layer, verifies its SHA256 digest,
+// and returns the path to the downloaded (still gzip-compressed) tarball.
+func fetchImageLayer(repository string, layer ImageManifestLayer, token string) (string, error) {
+	blobURL := fmt.Sprintf("https://%s/v2/%s/blobs/%s", defaultRegistry, repository, layer.Digest)
+	request, err := http.NewRequest(http.MethodGet, blobURL, nil)
+	if err != nil {
+		return "", err
+	}
+	request.Header.Set("Authorization", "Bearer "+token)
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("blob request for %s failed with status %s", layer.Digest, response.Status)
+	}
+
+	layerFile, err := os.CreateTemp("", "cocker-layer-*.tar.gz")
+	if err != nil {
+		return "", err
+	}
+	defer layerFile.Close()
+
+	hasher := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(layerFile, hasher), response.Body); err != nil {
+		os.Remove(layerFile.Name())
+		return "", err
+	}
+
+	actualDigest := "sha256:" + hex.EncodeToString(hasher.Sum(nil))
+	if actualDigest != layer.Digest {
+		os.Remove(layerFile.Name())
+		return "", fmt.Errorf("layer digest mismatch: expected %s, got %s", layer.Digest, actualDigest)
+	}
+
+	return layerFile.Name(), nil
+}
+
+// extractLayerWithWhiteouts extracts a gzip-compressed layer tarball into
+// destinationDir, applying AUFS-style ".wh." whiteout entries by deleting
+// the file or directory they shadow instead of writing them out.
+func extractLayerWithWhiteouts(layerTarballPath string, destinationDir string) error {
+	layerFile, err := os.Open(layerTarballPath)
+	if err != nil {
+		return err
+	}
+	defer layerFile.Close()
+
+	gzipReader, err := gzip.NewReader(layerFile)
+	if err != nil {
+		return err
+	}
+	defer gzipReader.Close()
+
+	tarReader := tar.NewReader(gzipReader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		entryName := filepath.Clean(header.Name)
+		baseName := filepath.Base(entryName)
+
+		if strings.HasPrefix(baseName, ".wh.") {
+			whitedOutPath, err := safeJoinUnderDir(destinationDir, filepath.Join(filepath.Dir(entryName), strings.TrimPrefix(baseName, ".wh.")))
+			if err != nil {
+				return fmt.Errorf("refusing to apply whiteout for %q: %w", entryName, err)
+			}
+			if err := os.RemoveAll(whitedOutPath); err != nil {
+				return fmt.Errorf("failed to apply whiteout for %q: %w", whitedOutPath, err)
+			}
+			continue
+		}
+
+		targetPath, err := safeJoinUnderDir(destinationDir, entryName)
+		if err != nil {
+			return fmt.Errorf("refusing to extract %q: %w", entryName, err)
+		}
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(targetPath, os.FileMode(header.Mode)); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(targetPath), 0755); err != nil {
+				return err
+			}
+			outputFile, err := os.OpenFile(targetPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, os.FileMode(header.Mode))
+			if err != nil {
+				return err
+			}
+			if _, err := io.Copy(outputFile, tarReader); err != nil {
+				outputFile.Close()
+				return err
+			}
+			outputFile.Close()
+		case tar.TypeSymlink:
+			os.Remove(targetPath)
+			if err := os.Symlink(header.Linkname, targetPath); err != nil {
+				return err
+			}
+		case tar.TypeLink:
+			linkSource, err := safeJoinUnderDir(destinationDir, header.Linkname)
+			if err != nil {
+				return fmt.Errorf("refusing to hardlink %q: %w", entryName, err)
+			}
+			if err := os.Link(linkSource, targetPath); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// safeJoinUnderDir joins destinationDir with relativePath and guarantees the
+// result stays within destinationDir, guarding against tar-slip entries
+// (e.g. "../../etc/cron.d/evil") in untrusted layer tarballs.
+func safeJoinUnderDir(destinationDir string, relativePath string) (string, error) {
+	cleanDestinationDir := filepath.Clean(destinationDir)
+	joinedPath := filepath.Join(cleanDestinationDir, relativePath)
+	if joinedPath != cleanDestinationDir && !strings.HasPrefix(joinedPath, cleanDestinationDir+string(os.PathSeparator)) {
+		return "", fmt.Errorf("path %q escapes destination directory %q", relativePath, destinationDir)
+	}
+	return joinedPath, nil
+}
+
+// createRootfsTarball tars up sourceDir into a gzip-compressed tarball at
+// tarballPath, matching the format extractRootFilesystem already knows how
+// to extract.
+func createRootfsTarball(sourceDir string, tarballPath string) error {
+	tarCommand := exec.Command("tar", "-czf", tarballPath, "-C", sourceDir, ".")
+	return tarCommand.Run()
+}
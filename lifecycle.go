@@ -0,0 +1,137 @@
+//go:build linux
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// stopGracePeriod is how long handleContainerStop waits for a SIGTERM'd
+// container to exit before escalating to SIGKILL.
+const stopGracePeriod = 10 * time.Second
+
+// processAlive reports whether pid is still alive, by probing it with
+// signal 0 (which performs permission/existence checks without actually
+// signaling the process).
+func processAlive(pid int) bool {
+	return syscall.Kill(pid, 0) == nil
+}
+
+// handleContainerStop implements `cocker stop <containerId>`: it sends
+// SIGTERM to the container's init process, then SIGKILL if it hasn't exited
+// within the grace period.
+func handleContainerStop() {
+	if len(os.Args) < 3 {
+		log.Fatal("Error: Usage: cocker stop <containerId>")
+	}
+	containerId := os.Args[2]
+
+	state, err := readContainerState(containerId)
+	if err != nil {
+		log.Fatal("Failed to look up container '"+containerId+"': ", err)
+	}
+
+	if state.Status != StatusRunning || !processAlive(state.Pid) {
+		fmt.Println("Container", containerId, "is not running")
+		return
+	}
+
+	fmt.Println("Sending SIGTERM to container", containerId, "(pid", state.Pid, ")")
+	if err := syscall.Kill(state.Pid, syscall.SIGTERM); err != nil {
+		log.Fatal("Failed to send SIGTERM to container '"+containerId+"': ", err)
+	}
+
+	deadline := time.Now().Add(stopGracePeriod)
+	for time.Now().Before(deadline) {
+		if !processAlive(state.Pid) {
+			fmt.Println("Container", containerId, "stopped")
+			return
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+
+	fmt.Println("Container", containerId, "did not stop within the grace period, sending SIGKILL")
+	if err := syscall.Kill(state.Pid, syscall.SIGKILL); err != nil {
+		log.Fatal("Failed to send SIGKILL to container '"+containerId+"': ", err)
+	}
+}
+
+// handleContainerRemove implements `cocker rm <containerId>`: it refuses to
+// remove a running container, then tears down its proc mount, cgroups and
+// root filesystem.
+func handleContainerRemove() {
+	if len(os.Args) < 3 {
+		log.Fatal("Error: Usage: cocker rm <containerId>")
+	}
+	containerId := os.Args[2]
+
+	state, err := readContainerState(containerId)
+	if err != nil {
+		log.Fatal("Failed to look up container '"+containerId+"': ", err)
+	}
+	if state.Status == StatusRunning && processAlive(state.Pid) {
+		log.Fatal("Error: container '" + containerId + "' is still running; stop it first")
+	}
+
+	containerRootFsPath := filepath.Join(containersDirectory, containerId)
+
+	procPath := filepath.Join(containerRootFsPath, "proc")
+	if err := syscall.Unmount(procPath, syscall.MNT_DETACH); err != nil && !os.IsNotExist(err) {
+		fmt.Println("Warning: failed to unmount", procPath, ":", err)
+	}
+
+	removeCgroupLimits(containerId)
+
+	if err := os.RemoveAll(containerRootFsPath); err != nil {
+		log.Fatal("Failed to remove container '"+containerId+"': ", err)
+	}
+	fmt.Println("Removed container", containerId)
+}
+
+// handleContainerLogs implements `cocker logs <containerId>`, printing the
+// stdout/stderr captured while the container ran.
+func handleContainerLogs() {
+	if len(os.Args) < 3 {
+		log.Fatal("Error: Usage: cocker logs <containerId>")
+	}
+	containerId := os.Args[2]
+	containerDir := filepath.Join(containersDirectory, containerId)
+
+	stdoutBytes, err := os.ReadFile(filepath.Join(containerDir, "stdout.log"))
+	if err != nil && !os.IsNotExist(err) {
+		log.Fatal("Failed to read stdout log for container '"+containerId+"': ", err)
+	}
+	os.Stdout.Write(stdoutBytes)
+
+	stderrBytes, err := os.ReadFile(filepath.Join(containerDir, "stderr.log"))
+	if err != nil && !os.IsNotExist(err) {
+		log.Fatal("Failed to read stderr log for container '"+containerId+"': ", err)
+	}
+	os.Stderr.Write(stderrBytes)
+}
+
+// handleContainerInspect implements `cocker inspect <containerId>`, pretty
+// printing the container's persisted state.
+func handleContainerInspect() {
+	if len(os.Args) < 3 {
+		log.Fatal("Error: Usage: cocker inspect <containerId>")
+	}
+	containerId := os.Args[2]
+
+	state, err := readContainerState(containerId)
+	if err != nil {
+		log.Fatal("Failed to look up container '"+containerId+"': ", err)
+	}
+
+	stateJSON, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		log.Fatal("Failed to marshal state for container '"+containerId+"': ", err)
+	}
+	fmt.Println(string(stateJSON))
+}
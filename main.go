@@ -4,28 +4,33 @@ package main
 
 import (
 	"fmt"
+	"io"
 	"log"
 	"math/rand"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
 )
 
 var containersDirectory string = "./containers"
-var rootFileSystemTarball string = "./ubuntu-base-22.04-base-amd64.tar.gz"
 
 func init() {
 	err := os.MkdirAll(containersDirectory, 0700)
 	if err != nil {
 		log.Fatal("Failed to create containers directory during initialization: ", err)
 	}
+	err = os.MkdirAll(imagesDirectory, 0700)
+	if err != nil {
+		log.Fatal("Failed to create images directory during initialization: ", err)
+	}
 
 	fmt.Println("===== Container Runtime Initialized =====")
 	fmt.Println("Container storage location:", containersDirectory)
-	fmt.Println("Using root filesystem:", rootFileSystemTarball)
+	fmt.Println("Image storage location:", imagesDirectory)
 	fmt.Println("=========================================")
 }
 
@@ -46,44 +51,120 @@ func main() {
 	case "ps":
 		fmt.Println("Listing all containers...")
 		listAllContainers()
+	case "exec":
+		fmt.Println("Attaching to running container...")
+		handleContainerExec()
+	case "_exec_child":
+		handleExecChild()
+	case "stop":
+		handleContainerStop()
+	case "rm":
+		handleContainerRemove()
+	case "logs":
+		handleContainerLogs()
+	case "inspect":
+		handleContainerInspect()
+	case "pull":
+		handleImagePull()
+	case "images":
+		handleImagesList()
+	case "rmi":
+		handleImageRemove()
 	default:
-		log.Fatal("Error: Unknown command '" + userCommand + "'. Valid commands are 'run' (to create a container) and 'ps' (to list containers).")
+		log.Fatal("Error: Unknown command '" + userCommand + "'. Valid commands are 'run', 'ps', 'exec', 'stop', 'rm', 'logs', 'inspect', 'pull', 'images' and 'rmi'.")
 	}
 }
 
 func handleContainerCreation(isChildProcess bool) {
 	var volumeMappings []string
 	var commandArguments []string
+	var resourceFlagArgs []string
+	var resourceLimits ResourceLimits
+	var forcedContainerId string
+	var imageReference string
+	useUserNamespace := false
 
 	fmt.Println("Parsing command arguments:", os.Args[2:])
 
 	for argumentIndex := 2; argumentIndex < len(os.Args); argumentIndex++ {
 		currentArgument := os.Args[argumentIndex]
-		if strings.HasPrefix(currentArgument, "-v=") {
+		switch {
+		case strings.HasPrefix(currentArgument, "-v="):
 			volumeMapping := strings.TrimPrefix(currentArgument, "-v=")
 			volumeMappings = append(volumeMappings, volumeMapping)
 			fmt.Println("Detected volume mapping:", volumeMapping)
-		} else {
+		case strings.HasPrefix(currentArgument, "--container-id="):
+			forcedContainerId = strings.TrimPrefix(currentArgument, "--container-id=")
+			fmt.Println("Using container ID from parent:", forcedContainerId)
+		case strings.HasPrefix(currentArgument, "--image="):
+			imageReference = strings.TrimPrefix(currentArgument, "--image=")
+			fmt.Println("Using image from parent:", imageReference)
+		case strings.HasPrefix(currentArgument, "--memory="):
+			memoryValue := strings.TrimPrefix(currentArgument, "--memory=")
+			memoryBytes, err := strconv.ParseInt(memoryValue, 10, 64)
+			if err != nil {
+				log.Fatal("Invalid --memory value '"+memoryValue+"': ", err)
+			}
+			resourceLimits.MemoryBytes = memoryBytes
+			resourceFlagArgs = append(resourceFlagArgs, currentArgument)
+			fmt.Println("Detected memory limit:", memoryBytes, "bytes")
+		case strings.HasPrefix(currentArgument, "--cpus="):
+			cpusValue := strings.TrimPrefix(currentArgument, "--cpus=")
+			cpus, err := strconv.ParseFloat(cpusValue, 64)
+			if err != nil {
+				log.Fatal("Invalid --cpus value '"+cpusValue+"': ", err)
+			}
+			resourceLimits.CPUs = cpus
+			resourceFlagArgs = append(resourceFlagArgs, currentArgument)
+			fmt.Println("Detected CPU limit:", cpus, "cpus")
+		case strings.HasPrefix(currentArgument, "--pids="):
+			pidsValue := strings.TrimPrefix(currentArgument, "--pids=")
+			pidsLimit, err := strconv.ParseInt(pidsValue, 10, 64)
+			if err != nil {
+				log.Fatal("Invalid --pids value '"+pidsValue+"': ", err)
+			}
+			resourceLimits.PidsLimit = pidsLimit
+			resourceFlagArgs = append(resourceFlagArgs, currentArgument)
+			fmt.Println("Detected pids limit:", pidsLimit)
+		case currentArgument == "--userns":
+			useUserNamespace = true
+			fmt.Println("User namespace isolation requested via --userns")
+		default:
+			if !isChildProcess && imageReference == "" {
+				imageReference = currentArgument
+				fmt.Println("Detected image reference:", imageReference)
+				continue
+			}
 			commandArguments = append(commandArguments, currentArgument)
 		}
 	}
 
 	fmt.Println("Volume mappings:", volumeMappings)
+	fmt.Println("Resource limits:", resourceLimits)
+	fmt.Println("Image reference:", imageReference)
 	fmt.Println("Command arguments:", commandArguments)
 
-	startContainer(commandArguments, volumeMappings, isChildProcess)
+	startContainer(commandArguments, volumeMappings, resourceFlagArgs, resourceLimits, useUserNamespace, forcedContainerId, imageReference, isChildProcess)
 }
 
-func startContainer(commandArgs []string, volumeMappings []string, isChildProcess bool) {
+func startContainer(commandArgs []string, volumeMappings []string, resourceFlagArgs []string, resourceLimits ResourceLimits, useUserNamespace bool, forcedContainerId string, imageReference string, isChildProcess bool) {
+	if !isChildProcess && imageReference == "" {
+		log.Fatal("Error: You must specify an image to run! Example: ./container run ubuntu:22.04 /bin/bash")
+	}
 	if len(commandArgs) == 0 {
-		log.Fatal("Error: You must specify a command to run in the container! Example: ./container run /bin/bash")
+		log.Fatal("Error: You must specify a command to run in the container! Example: ./container run ubuntu:22.04 /bin/bash")
 	}
 
 	var executableToRun string
 	var executableArguments []string
+	var containerId string
 
 	if isChildProcess {
 		fmt.Println("Child process: preparing to execute user command inside container")
+		containerId = forcedContainerId
+		if containerId == "" {
+			containerId = "container-" + generateRandomIdentifier(24)
+		}
 		executableToRun = commandArgs[0]
 		if len(commandArgs) > 1 {
 			executableArguments = commandArgs[1:]
@@ -93,38 +174,156 @@ func startContainer(commandArgs []string, volumeMappings []string, isChildProces
 		fmt.Println("Child: Will execute:", executableToRun, "with arguments:", executableArguments)
 	} else {
 		fmt.Println("Parent process: preparing to create container environment")
+		containerId = "container-" + generateRandomIdentifier(24)
 		ourExecutablePath, err := os.Executable()
 		if err != nil {
 			log.Fatal("Failed to get our own executable path: ", err)
 		}
 		executableToRun = ourExecutablePath
-		executableArguments = append([]string{"_child"}, volumeMappings...)
+		executableArguments = []string{"_child", "--container-id=" + containerId, "--image=" + imageReference}
+		for _, volumeMapping := range volumeMappings {
+			executableArguments = append(executableArguments, "-v="+volumeMapping)
+		}
+		executableArguments = append(executableArguments, resourceFlagArgs...)
 		executableArguments = append(executableArguments, commandArgs...)
 		fmt.Println("Parent: Will execute:", executableToRun, "with arguments:", executableArguments)
 	}
 
 	commandToExecute := exec.Command(executableToRun, executableArguments...)
 	commandToExecute.Stdin = os.Stdin
-	commandToExecute.Stdout = os.Stdout
-	commandToExecute.Stderr = os.Stderr
 
 	if isChildProcess {
-		containerId := "container-" + generateRandomIdentifier(24)
+		commandToExecute.Stdout = os.Stdout
+		commandToExecute.Stderr = os.Stderr
+	} else {
+		containerDir := filepath.Join(containersDirectory, containerId)
+		if err := os.MkdirAll(containerDir, 0700); err != nil {
+			log.Fatal("Failed to create container directory: ", err)
+		}
+		stdoutLogFile, err := os.Create(filepath.Join(containerDir, "stdout.log"))
+		if err != nil {
+			log.Fatal("Failed to create stdout log file: ", err)
+		}
+		defer stdoutLogFile.Close()
+		stderrLogFile, err := os.Create(filepath.Join(containerDir, "stderr.log"))
+		if err != nil {
+			log.Fatal("Failed to create stderr log file: ", err)
+		}
+		defer stderrLogFile.Close()
+
+		commandToExecute.Stdout = io.MultiWriter(os.Stdout, stdoutLogFile)
+		commandToExecute.Stderr = io.MultiWriter(os.Stderr, stderrLogFile)
+	}
+
+	if !isChildProcess {
+		fmt.Println("Parent: isolating container into new UTS/PID/mount/IPC/network namespaces")
+		cloneFlags := syscall.CLONE_NEWUTS | syscall.CLONE_NEWPID | syscall.CLONE_NEWNS | syscall.CLONE_NEWIPC | syscall.CLONE_NEWNET
+		sysProcAttr := &syscall.SysProcAttr{
+			Cloneflags:   uintptr(cloneFlags),
+			Unshareflags: syscall.CLONE_NEWNS,
+		}
+		if useUserNamespace {
+			fmt.Println("Parent: mapping invoking uid/gid to root inside the container's user namespace")
+			sysProcAttr.Cloneflags |= syscall.CLONE_NEWUSER
+			sysProcAttr.UidMappings = []syscall.SysProcIDMap{
+				{ContainerID: 0, HostID: os.Getuid(), Size: 1},
+			}
+			sysProcAttr.GidMappings = []syscall.SysProcIDMap{
+				{ContainerID: 0, HostID: os.Getgid(), Size: 1},
+			}
+		}
+		commandToExecute.SysProcAttr = sysProcAttr
+	}
+
+	if isChildProcess {
 		fmt.Println("\n===== CONTAINER SETUP START =====")
 		fmt.Println("Container ID:", containerId)
 		syscall.Sethostname([]byte(containerId))
+
+		// Make the mount tree private before any mount work (rootfs
+		// extraction, volume binds, pivot_root), so none of it propagates
+		// back out to the host's mount namespace (the default "shared"
+		// propagation inherited from the host would otherwise leak these
+		// mounts onto the host).
+		if err := syscall.Mount("", "/", "", syscall.MS_PRIVATE|syscall.MS_REC, ""); err != nil {
+			log.Fatal("Failed to make mount tree private: ", err)
+		}
+
 		containerRootFsPath := filepath.Join(containersDirectory, containerId)
-		extractRootFilesystem(containerRootFsPath, rootFileSystemTarball)
+		rootFsTarball, err := resolveImageRootfsTarball(imageReference)
+		if err != nil {
+			log.Fatal("Failed to resolve image: ", err)
+		}
+		extractRootFilesystem(containerRootFsPath, rootFsTarball)
 		fmt.Println("Root filesystem extracted successfully")
+		if err := applyVolumeMounts(containerRootFsPath, volumeMappings); err != nil {
+			log.Fatal("Failed to apply volume mounts: ", err)
+		}
+		// Cgroups live under the host's real /sys/fs/cgroup, so this must
+		// run before changeContainerRoot swaps / for the container image -
+		// afterwards the path would resolve inside the rootfs instead.
+		if err := applyCgroupLimits(containerId, resourceLimits); err != nil {
+			log.Fatal("Failed to apply cgroup limits: ", err)
+		}
 		changeContainerRoot(containerRootFsPath)
 		syscall.Mount("proc", "/proc", "proc", 0, "")
 		fmt.Println("\n===== CONTAINER READY =====")
 		fmt.Println("Container ID:", containerId)
 		fmt.Println("Container PID:", os.Getpid())
+
+		executionError := commandToExecute.Run()
+		exitCode := 0
+		if executionError != nil {
+			if exitError, ok := executionError.(*exec.ExitError); ok {
+				exitCode = exitError.ExitCode()
+			} else {
+				log.Fatal("Failed to execute command in container: ", executionError)
+			}
+		}
+		fmt.Println("\nCommand execution completed with exit code:", exitCode)
+		os.Exit(exitCode)
+	}
+
+	initialState := ContainerState{
+		Id:        containerId,
+		Image:     imageReference,
+		Command:   commandArgs[0],
+		Args:      commandArgs[1:],
+		Volumes:   volumeMappings,
+		Limits:    resourceLimits,
+		Status:    StatusRunning,
+		CreatedAt: time.Now(),
+	}
+	if err := commandToExecute.Start(); err != nil {
+		log.Fatal("Failed to start container: ", err)
+	}
+	initialState.Pid = commandToExecute.Process.Pid
+	if err := writeContainerState(initialState); err != nil {
+		log.Fatal("Failed to persist container state: ", err)
 	}
 
-	executionError := commandToExecute.Run()
-	exitCode := commandToExecute.ProcessState.ExitCode()
+	exitCodeChannel := make(chan int, 1)
+	go func() {
+		waitErr := commandToExecute.Wait()
+		exitCode := 0
+		if waitErr != nil {
+			if exitError, ok := waitErr.(*exec.ExitError); ok {
+				exitCode = exitError.ExitCode()
+			} else {
+				exitCode = -1
+			}
+		}
+		finalState := initialState
+		finalState.Status = StatusExited
+		finalState.ExitCode = exitCode
+		if err := writeContainerState(finalState); err != nil {
+			fmt.Println("Warning: failed to persist final container state:", err)
+		}
+		removeCgroupLimits(containerId)
+		exitCodeChannel <- exitCode
+	}()
+
+	exitCode := <-exitCodeChannel
 	fmt.Println("\nCommand execution completed with exit code:", exitCode)
 	os.Exit(exitCode)
 }
@@ -139,15 +338,19 @@ func listAllContainers() {
 		fmt.Println("No containers found.")
 		return
 	}
-	fmt.Println("CONTAINER ID\t\t\tCREATION TIME")
-	fmt.Println("--------------------------------------------")
+	fmt.Println("CONTAINER ID\t\t\tSTATUS\t\tCOMMAND\t\tCREATED")
+	fmt.Println("--------------------------------------------------------------------")
 	for _, containerEntry := range containerEntries {
-		containerInfo, err := containerEntry.Info()
+		if !containerEntry.IsDir() {
+			continue
+		}
+		state, err := readContainerState(containerEntry.Name())
 		if err != nil {
-			fmt.Printf("Warning: Could not get info for container '%s': %v\n", containerEntry.Name(), err)
+			fmt.Printf("Warning: Could not read state for container '%s': %v\n", containerEntry.Name(), err)
 			continue
 		}
-		fmt.Printf("%s\t%s\n", containerEntry.Name(), containerInfo.ModTime().Format(time.UnixDate))
+		command := strings.Join(append([]string{state.Command}, state.Args...), " ")
+		fmt.Printf("%s\t%s\t%s\t%s\n", state.Id, state.Status, command, state.CreatedAt.Format(time.UnixDate))
 	}
 }
 
@@ -171,6 +374,41 @@ func extractRootFilesystem(destinationPath string, tarballPath string) {
 	}
 }
 
+// changeContainerRoot replaces the child process's root filesystem with
+// newRootPath via pivot_root, so the host's rootfs is no longer reachable
+// from inside the container.
 func changeContainerRoot(newRootPath string) {
-	syscall.Mount(newRootPath, newRootPath, "", syscall.MS_BIND, "")
+	// pivot_root requires newRootPath to be a mount point, so bind-mount it
+	// onto itself first.
+	if err := syscall.Mount(newRootPath, newRootPath, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+		log.Fatal("Failed to bind-mount new root onto itself: ", err)
+	}
+
+	if err := os.Chdir(newRootPath); err != nil {
+		log.Fatal("Failed to chdir into new root: ", err)
+	}
+
+	const oldRootDirName = ".pivot_root"
+	if err := os.MkdirAll(oldRootDirName, 0700); err != nil {
+		log.Fatal("Failed to create pivot_root directory: ", err)
+	}
+
+	// We are already inside newRootPath, so refer to it as "." - a relative
+	// newRootPath would otherwise be resolved a second time from here.
+	if err := syscall.PivotRoot(".", oldRootDirName); err != nil {
+		log.Fatal("Failed to pivot_root into new root: ", err)
+	}
+
+	if err := os.Chdir("/"); err != nil {
+		log.Fatal("Failed to chdir into / after pivot_root: ", err)
+	}
+
+	oldRootPath := filepath.Join("/", oldRootDirName)
+	if err := syscall.Unmount(oldRootPath, syscall.MNT_DETACH); err != nil {
+		log.Fatal("Failed to lazily unmount old root: ", err)
+	}
+
+	if err := os.RemoveAll(oldRootPath); err != nil {
+		log.Fatal("Failed to remove old root directory: ", err)
+	}
 }
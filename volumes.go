@@ -0,0 +1,84 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// VolumeMount is a single parsed -v=host:container[:ro] mapping.
+type VolumeMount struct {
+	HostPath      string
+	ContainerPath string
+	ReadOnly      bool
+}
+
+// parseVolumeMapping parses a single "host:container[:ro]" mapping string,
+// resolving the host path to an absolute path and rejecting ".." traversal
+// on the container side.
+func parseVolumeMapping(mapping string) (VolumeMount, error) {
+	parts := strings.Split(mapping, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return VolumeMount{}, fmt.Errorf("invalid volume mapping %q: expected host:container[:ro]", mapping)
+	}
+
+	hostPath, err := filepath.Abs(parts[0])
+	if err != nil {
+		return VolumeMount{}, fmt.Errorf("failed to resolve host path %q: %w", parts[0], err)
+	}
+
+	containerPath := parts[1]
+	if strings.Contains(containerPath, "..") {
+		return VolumeMount{}, fmt.Errorf("invalid volume mapping %q: container path must not contain '..'", mapping)
+	}
+
+	readOnly := false
+	if len(parts) == 3 {
+		if parts[2] != "ro" {
+			return VolumeMount{}, fmt.Errorf("invalid volume mapping %q: unknown option %q", mapping, parts[2])
+		}
+		readOnly = true
+	}
+
+	return VolumeMount{HostPath: hostPath, ContainerPath: containerPath, ReadOnly: readOnly}, nil
+}
+
+// applyVolumeMounts bind-mounts each host:container[:ro] mapping into the
+// container's root filesystem. It must run after extractRootFilesystem but
+// before changeContainerRoot, while rootFsPath is still reachable from the
+// host's mount namespace.
+func applyVolumeMounts(rootFsPath string, mappings []string) error {
+	for _, mapping := range mappings {
+		volumeMount, err := parseVolumeMapping(mapping)
+		if err != nil {
+			return err
+		}
+
+		if _, err := os.Stat(volumeMount.HostPath); err != nil {
+			return fmt.Errorf("host path %q does not exist: %w", volumeMount.HostPath, err)
+		}
+
+		targetPath := filepath.Join(rootFsPath, volumeMount.ContainerPath)
+		if err := os.MkdirAll(targetPath, 0755); err != nil {
+			return fmt.Errorf("failed to create mount target %q: %w", targetPath, err)
+		}
+
+		fmt.Println("Bind-mounting volume:", volumeMount.HostPath, "->", targetPath)
+		if err := syscall.Mount(volumeMount.HostPath, targetPath, "", syscall.MS_BIND, ""); err != nil {
+			return fmt.Errorf("failed to bind-mount %q onto %q: %w", volumeMount.HostPath, targetPath, err)
+		}
+
+		if volumeMount.ReadOnly {
+			fmt.Println("Remounting volume read-only:", targetPath)
+			remountFlags := uintptr(syscall.MS_BIND | syscall.MS_REMOUNT | syscall.MS_RDONLY)
+			if err := syscall.Mount(volumeMount.HostPath, targetPath, "", remountFlags, ""); err != nil {
+				return fmt.Errorf("failed to remount %q read-only: %w", targetPath, err)
+			}
+		}
+	}
+	return nil
+}
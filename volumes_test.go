@@ -0,0 +1,91 @@
+//go:build linux
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestParseVolumeMapping(t *testing.T) {
+	cases := []struct {
+		name      string
+		mapping   string
+		wantError bool
+		wantRO    bool
+	}{
+		{"basic read-write", "/tmp/src:/data", false, false},
+		{"read-only suffix", "/tmp/src:/data:ro", false, true},
+		{"rejects container path traversal", "/tmp/src:/data/../../etc", true, false},
+		{"rejects unknown option", "/tmp/src:/data:rw", true, false},
+		{"rejects malformed mapping", "/tmp/src", true, false},
+	}
+
+	for _, testCase := range cases {
+		t.Run(testCase.name, func(t *testing.T) {
+			volumeMount, err := parseVolumeMapping(testCase.mapping)
+			if testCase.wantError {
+				if err == nil {
+					t.Fatalf("expected an error for mapping %q, got none", testCase.mapping)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error for mapping %q: %v", testCase.mapping, err)
+			}
+			if volumeMount.ReadOnly != testCase.wantRO {
+				t.Errorf("ReadOnly = %v, want %v", volumeMount.ReadOnly, testCase.wantRO)
+			}
+		})
+	}
+}
+
+func TestApplyVolumeMountsRejectsMissingHostPath(t *testing.T) {
+	rootFsPath := t.TempDir()
+	err := applyVolumeMounts(rootFsPath, []string{"/no/such/host/path:/data"})
+	if err == nil {
+		t.Fatal("expected an error for a host path that does not exist")
+	}
+}
+
+func TestApplyVolumeMountsCreatesNestedContainerDirectory(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("bind-mounting requires root privileges")
+	}
+
+	rootFsPath := t.TempDir()
+	hostPath := t.TempDir()
+
+	if err := applyVolumeMounts(rootFsPath, []string{hostPath + ":/var/lib/nested/data"}); err != nil {
+		t.Fatalf("applyVolumeMounts failed: %v", err)
+	}
+
+	targetPath := filepath.Join(rootFsPath, "var/lib/nested/data")
+	defer syscall.Unmount(targetPath, syscall.MNT_DETACH)
+
+	if _, err := os.Stat(targetPath); err != nil {
+		t.Fatalf("expected nested mount target to exist: %v", err)
+	}
+}
+
+func TestApplyVolumeMountsEnforcesReadOnly(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("bind-mounting requires root privileges")
+	}
+
+	rootFsPath := t.TempDir()
+	hostPath := t.TempDir()
+
+	if err := applyVolumeMounts(rootFsPath, []string{hostPath + ":/data:ro"}); err != nil {
+		t.Fatalf("applyVolumeMounts failed: %v", err)
+	}
+
+	targetPath := filepath.Join(rootFsPath, "data")
+	defer syscall.Unmount(targetPath, syscall.MNT_DETACH)
+
+	if err := os.WriteFile(filepath.Join(targetPath, "should-fail"), []byte("data"), 0644); err == nil {
+		t.Fatal("expected write into a read-only bind mount to fail, but it succeeded")
+	}
+}